@@ -0,0 +1,88 @@
+// Package bloomfilter implements a small, dependency-free bloom filter used
+// to cheaply pre-filter candidates for expensive lookups (e.g. deciding
+// whether a mailbox is worth opening during a retention scan).
+package bloomfilter
+
+import (
+	"hash/fnv"
+)
+
+// Filter is a fixed-size bloom filter.  It is safe for concurrent reads, but
+// callers must serialize Add calls themselves (see datastore.RetentionScanner
+// for an example using a mutex).
+type Filter struct {
+	bits   []uint64
+	nbits  uint
+	hashes int
+}
+
+// New returns a Filter with room for approximately size bits and using the
+// given number of hash functions per item.  A higher hash count reduces the
+// false positive rate at the cost of more work per Add/Test.
+func New(size uint, hashes int) *Filter {
+	if size == 0 {
+		size = 1
+	}
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &Filter{
+		bits:   make([]uint64, (size+63)/64),
+		nbits:  size,
+		hashes: hashes,
+	}
+}
+
+// Add records id as present in the filter.
+func (f *Filter) Add(id string) {
+	h1, h2 := f.seeds(id)
+	for i := 0; i < f.hashes; i++ {
+		f.set(f.index(h1, h2, i))
+	}
+}
+
+// Test returns true if id may have been added to the filter.  A false
+// result means id was definitely never added; a true result may be a false
+// positive.
+func (f *Filter) Test(id string) bool {
+	h1, h2 := f.seeds(id)
+	for i := 0; i < f.hashes; i++ {
+		if !f.get(f.index(h1, h2, i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset clears the filter so it can be rebuilt from scratch.
+func (f *Filter) Reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
+
+func (f *Filter) seeds(id string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	h1 := h.Sum64()
+	h.Reset()
+	_, _ = h.Write([]byte(id))
+	_, _ = h.Write([]byte{0xff})
+	h2 := h.Sum64()
+	return h1, h2
+}
+
+// index implements double hashing (Kirsch-Mitzenmacher) to derive the i'th
+// hash from two independent hashes, avoiding the cost of nhashes distinct
+// hash functions.
+func (f *Filter) index(h1, h2 uint64, i int) uint {
+	return uint((h1 + uint64(i)*h2) % uint64(f.nbits))
+}
+
+func (f *Filter) set(bit uint) {
+	f.bits[bit/64] |= 1 << (bit % 64)
+}
+
+func (f *Filter) get(bit uint) bool {
+	return f.bits[bit/64]&(1<<(bit%64)) != 0
+}
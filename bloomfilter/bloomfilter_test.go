@@ -0,0 +1,43 @@
+package bloomfilter
+
+import "testing"
+
+// TestAddTestNoFalseNegatives verifies every id that was Add-ed tests
+// positive, which is the only guarantee a bloom filter makes (false
+// positives are expected and tolerated by callers).
+func TestAddTestNoFalseNegatives(t *testing.T) {
+	f := New(1024, 4)
+	ids := []string{"inbox1", "inbox2", "mailbox-with-dashes", "", "utf8-ü-id"}
+	for _, id := range ids {
+		f.Add(id)
+	}
+	for _, id := range ids {
+		if !f.Test(id) {
+			t.Errorf("Test(%q) = false after Add(%q), want true", id, id)
+		}
+	}
+}
+
+// TestTestAbsent verifies an id that was never added tests negative,
+// using a filter large enough relative to its contents that a collision
+// is implausible.
+func TestTestAbsent(t *testing.T) {
+	f := New(4096, 4)
+	f.Add("present")
+	if f.Test("absent") {
+		t.Errorf("Test(%q) = true, want false", "absent")
+	}
+}
+
+// TestReset verifies Reset clears previously Add-ed ids.
+func TestReset(t *testing.T) {
+	f := New(1024, 4)
+	f.Add("id")
+	if !f.Test("id") {
+		t.Fatalf("Test(%q) = false before Reset, want true", "id")
+	}
+	f.Reset()
+	if f.Test("id") {
+		t.Errorf("Test(%q) = true after Reset, want false", "id")
+	}
+}
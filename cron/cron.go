@@ -0,0 +1,159 @@
+// Package cron provides a small registry of named, recurring background
+// tasks (retention, metrics flush, future indexers), each tracking its own
+// schedule, execution count and last-run status, and triggerable on demand
+// via RunNow.  It exists so the admin HTTP endpoint can present and manage
+// all of Inbucket's background jobs through a single interface instead of
+// each subsystem rolling its own ticker loop.
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jhillyerd/inbucket/log"
+)
+
+// ErrAlreadyRunning is returned by RunNow when the task it was asked to
+// trigger is already executing, either from its own schedule or a prior
+// RunNow call.
+var ErrAlreadyRunning = errors.New("cron: task already running")
+
+// ErrUnknownTask is returned by RunNow when no task with the given name
+// has been registered.
+var ErrUnknownTask = errors.New("cron: unknown task")
+
+// Task is a named unit of work the Registry runs on a recurring schedule.
+type Task struct {
+	Name     string
+	Schedule time.Duration
+	Run      func(ctx context.Context) error
+
+	running   int32 // atomic: 1 while Run is executing, guards re-entrancy
+	mu        sync.Mutex
+	execCount int
+	lastRun   time.Time
+	lastErr   error
+}
+
+// Status is a point-in-time snapshot of a Task's execution history.
+type Status struct {
+	Name      string
+	Schedule  time.Duration
+	ExecCount int
+	LastRun   time.Time
+	LastErr   error
+}
+
+func (t *Task) status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Status{
+		Name:      t.Name,
+		Schedule:  t.Schedule,
+		ExecCount: t.execCount,
+		LastRun:   t.lastRun,
+		LastErr:   t.lastErr,
+	}
+}
+
+func (t *Task) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.execCount++
+	t.lastRun = time.Now()
+	t.lastErr = err
+}
+
+// tryRun executes the task's Run function unless it is already running,
+// in which case it returns ErrAlreadyRunning without touching run stats.
+// This is the only path that invokes Run, so the scheduled loop and a
+// manual RunNow can never execute the same task concurrently.
+func (t *Task) tryRun(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&t.running, 0, 1) {
+		return ErrAlreadyRunning
+	}
+	defer atomic.StoreInt32(&t.running, 0)
+	err := t.Run(ctx)
+	t.record(err)
+	return err
+}
+
+// Registry holds the set of Tasks Inbucket runs in the background.
+type Registry struct {
+	mu    sync.Mutex
+	tasks map[string]*Task
+}
+
+// NewRegistry returns an empty task Registry.
+func NewRegistry() *Registry {
+	return &Registry{tasks: make(map[string]*Task)}
+}
+
+// Register adds task to the registry and starts its background loop.
+// ctx controls the task's lifetime; its loop stops when ctx is cancelled.
+func (r *Registry) Register(ctx context.Context, task *Task) error {
+	r.mu.Lock()
+	if _, exists := r.tasks[task.Name]; exists {
+		r.mu.Unlock()
+		return fmt.Errorf("cron: task %q already registered", task.Name)
+	}
+	r.tasks[task.Name] = task
+	r.mu.Unlock()
+	go r.loop(ctx, task)
+	return nil
+}
+
+func (r *Registry) loop(ctx context.Context, task *Task) {
+	ticker := time.NewTicker(task.Schedule)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.exec(ctx, task)
+		}
+	}
+}
+
+func (r *Registry) exec(ctx context.Context, task *Task) {
+	err := task.tryRun(ctx)
+	if err == nil || err == ErrAlreadyRunning {
+		if err == ErrAlreadyRunning {
+			log.Tracef("cron: task %q still running, skipping scheduled trigger", task.Name)
+		}
+		return
+	}
+	log.Errorf("cron: task %q failed: %v", task.Name, err)
+}
+
+// RunNow immediately executes the named task out of band, ignoring its
+// schedule.  Intended for use by the admin HTTP endpoint's manual trigger.
+// It returns ErrAlreadyRunning rather than starting a second, overlapping
+// execution if the task's scheduled run (or a previous RunNow) is still
+// in flight.
+func (r *Registry) RunNow(ctx context.Context, name string) error {
+	r.mu.Lock()
+	task, ok := r.tasks[name]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownTask, name)
+	}
+	return task.tryRun(ctx)
+}
+
+// Status returns a snapshot of every registered task, for display on the
+// admin dashboard.
+func (r *Registry) Status() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]Status, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		statuses = append(statuses, task.status())
+	}
+	return statuses
+}
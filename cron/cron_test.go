@@ -0,0 +1,81 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTaskTryRunRejectsReentrant verifies that a second tryRun call made
+// while the first is still executing is rejected with ErrAlreadyRunning
+// instead of running concurrently, which previously let a manual RunNow
+// race with the scheduled tick (or another RunNow) and launch two
+// overlapping executions of the same task.
+func TestTaskTryRunRejectsReentrant(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	task := &Task{
+		Name:     "test",
+		Schedule: time.Hour,
+		Run: func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := task.tryRun(context.Background()); err != nil {
+			t.Errorf("first tryRun: unexpected error: %v", err)
+		}
+	}()
+
+	<-started
+	if err := task.tryRun(context.Background()); err != ErrAlreadyRunning {
+		t.Errorf("second tryRun: got %v, want ErrAlreadyRunning", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if err := task.tryRun(context.Background()); err != nil {
+		t.Errorf("tryRun after completion: unexpected error: %v", err)
+	}
+}
+
+// TestRegistryRunNowRejectsReentrant exercises the same scenario through
+// the public Registry.RunNow API.
+func TestRegistryRunNowRejectsReentrant(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	reg := &Registry{tasks: map[string]*Task{
+		"test": {
+			Name:     "test",
+			Schedule: time.Hour,
+			Run: func(ctx context.Context) error {
+				close(started)
+				<-release
+				return nil
+			},
+		},
+	}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- reg.RunNow(context.Background(), "test")
+	}()
+
+	<-started
+	if err := reg.RunNow(context.Background(), "test"); err != ErrAlreadyRunning {
+		t.Errorf("concurrent RunNow: got %v, want ErrAlreadyRunning", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("first RunNow: unexpected error: %v", err)
+	}
+}
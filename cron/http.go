@@ -0,0 +1,28 @@
+package cron
+
+import (
+	"errors"
+	"net/http"
+)
+
+// RunNowHandler returns an http.HandlerFunc that triggers RunNow for the
+// named task, for mounting on an admin endpoint (e.g.
+// POST /admin/tasks/{name}/run). RunNow runs the task synchronously, so the
+// handler responds only once the run has finished: 202 Accepted if it
+// completed without error, 409 Conflict if the task was already running,
+// 404 Not Found if no such task is registered, and 500 Internal Server
+// Error if the task itself returned an error.
+func (r *Registry) RunNowHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch err := r.RunNow(req.Context(), name); {
+		case err == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, ErrAlreadyRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrUnknownTask):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
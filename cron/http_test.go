@@ -0,0 +1,69 @@
+package cron
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunNowHandlerAccepted(t *testing.T) {
+	reg := &Registry{tasks: map[string]*Task{
+		"test": {
+			Name:     "test",
+			Schedule: time.Hour,
+			Run:      func(ctx context.Context) error { return nil },
+		},
+	}}
+
+	w := httptest.NewRecorder()
+	reg.RunNowHandler("test")(w, httptest.NewRequest(http.MethodPost, "/admin/tasks/test/run", nil))
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+}
+
+func TestRunNowHandlerUnknownTask(t *testing.T) {
+	reg := NewRegistry()
+
+	w := httptest.NewRecorder()
+	reg.RunNowHandler("missing")(w, httptest.NewRequest(http.MethodPost, "/admin/tasks/missing/run", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRunNowHandlerAlreadyRunning(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	reg := &Registry{tasks: map[string]*Task{
+		"test": {
+			Name:     "test",
+			Schedule: time.Hour,
+			Run: func(ctx context.Context) error {
+				close(started)
+				<-release
+				return nil
+			},
+		},
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reg.RunNowHandler("test")(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/tasks/test/run", nil))
+	}()
+
+	<-started
+	w := httptest.NewRecorder()
+	reg.RunNowHandler("test")(w, httptest.NewRequest(http.MethodPost, "/admin/tasks/test/run", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterLimitsBurst verifies that once perSec operations have
+// been admitted, a further one blocks until a ctx deadline well short of
+// the one-second window expires, rather than being let through.
+func TestRateLimiterLimitsBurst(t *testing.T) {
+	rl := newRateLimiter(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := rl.Wait(ctx); err != nil {
+			t.Fatalf("Wait %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := rl.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("4th Wait: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// TestRateLimiterDisabled verifies a perSec of 0 never blocks.
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := newRateLimiter(0)
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if err := rl.Wait(ctx); err != nil {
+			t.Fatalf("Wait %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+// TestRateLimiterConcurrentAdmission verifies perSec concurrent callers
+// are all admitted without error (no deadlock, no spurious rejection).
+func TestRateLimiterConcurrentAdmission(t *testing.T) {
+	const perSec = 5
+	rl := newRateLimiter(perSec)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, perSec)
+	for i := 0; i < perSec; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- rl.Wait(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent Wait: unexpected error: %v", err)
+		}
+	}
+}
+
+// TestRateLimiterWindowSlides verifies an operation beyond perSec is
+// admitted once the one-second trailing window has actually elapsed,
+// not merely once a calendar-second boundary is crossed.
+func TestRateLimiterWindowSlides(t *testing.T) {
+	rl := newRateLimiter(1)
+	ctx := context.Background()
+	if err := rl.Wait(ctx); err != nil {
+		t.Fatalf("first Wait: unexpected error: %v", err)
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, 1200*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx2); err != nil {
+		t.Errorf("second Wait after window elapsed: unexpected error: %v", err)
+	}
+}
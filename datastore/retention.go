@@ -2,14 +2,44 @@ package datastore
 
 import (
 	"container/list"
+	"context"
 	"expvar"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jhillyerd/inbucket/bloomfilter"
 	"github.com/jhillyerd/inbucket/config"
+	"github.com/jhillyerd/inbucket/cron"
 	"github.com/jhillyerd/inbucket/log"
+	"github.com/jhillyerd/inbucket/retention"
 )
 
+// retentionTaskName identifies the scanner's task in the cron.Registry.
+const retentionTaskName = "retention"
+
+// RetentionStatus controls how much the bloom filter pre-filter is trusted
+// during a retention scan.
+type RetentionStatus int
+
+const (
+	// RetentionDisabled scans every mailbox on each pass, ignoring the
+	// bloom filter entirely.  This is the original behavior.
+	RetentionDisabled RetentionStatus = iota
+	// RetentionEnabled skips mailboxes the bloom filter says contain no
+	// expired messages, and deletes the ones that pass.
+	RetentionEnabled
+	// RetentionDebug behaves like RetentionEnabled but logs what would be
+	// deleted instead of calling msg.Delete(), for validating the filter.
+	RetentionDebug
+)
+
+// defaultMaxTimeSkew tolerates clock drift between a message's Date header
+// and the system clock when deciding if it is a candidate for expiry.
+const defaultMaxTimeSkew = 72 * time.Hour
+
 var (
 	retentionScanCompleted   = time.Now()
 	retentionScanCompletedMu sync.RWMutex
@@ -19,6 +49,10 @@ var (
 	expRetentionPeriod       = new(expvar.Int)
 	expRetainedCurrent       = new(expvar.Int)
 
+	// Worker pool gauges
+	expRetentionWorkersActive     = new(expvar.Int)
+	expRetentionMailboxQueueDepth = new(expvar.Int)
+
 	// History of certain stats
 	retentionDeletesHist = list.New()
 	retainedHist         = list.New()
@@ -36,6 +70,8 @@ func init() {
 	rm.Set("Period", expRetentionPeriod)
 	rm.Set("RetainedHist", expRetainedHist)
 	rm.Set("RetainedCurrent", expRetainedCurrent)
+	rm.Set("WorkersActive", expRetentionWorkersActive)
+	rm.Set("MailboxQueueDepth", expRetentionMailboxQueueDepth)
 
 	log.AddTickerFunc(func() {
 		expRetentionDeletesHist.Set(log.PushMetric(retentionDeletesHist, expRetentionDeletesTotal))
@@ -45,117 +81,442 @@ func init() {
 
 // RetentionScanner looks for messages older than the configured retention period and deletes them.
 type RetentionScanner struct {
-	globalShutdown    chan bool // Closes when Inbucket needs to shut down
+	ctx               context.Context
+	cancel            context.CancelFunc
 	retentionShutdown chan bool // Closed after the scanner has shut down
 	ds                DataStore
 	retentionPeriod   time.Duration
 	retentionSleep    time.Duration
+	maxTimeSkew       time.Duration
+
+	status            RetentionStatus
+	filter            *bloomfilter.Filter // possibly-expired message IDs
+	mboxFilter        *bloomfilter.Filter // mailboxes holding a possibly-expired message
+	mu                sync.Mutex          // guards filter/mboxFilter, updated as messages are stored
+	bloomRebuildEvery uint64              // force a full, unfiltered pass every N scans
+	scanCount         uint64              // atomically incremented once per doScan call
+
+	policies retention.PolicyStore
+
+	concurrency int
+	limiter     *rateLimiter
+
+	// DryRun causes every would-be deletion to be logged and passed to
+	// registered hooks without actually calling msg.Delete(), so operators
+	// can validate a policy change before relying on it.
+	DryRun bool
+
+	hooksMu sync.Mutex
+	hooks   []RetentionHook
+
+	// scanWG tracks in-flight doScan executions, so Shutdown can wait for
+	// them (and any hook I/O they trigger) to actually finish instead of
+	// returning while a scan is still running in the background.
+	scanWG sync.WaitGroup
+}
+
+// RetentionHook lets callers observe, veto, or react to message deletions
+// performed by a RetentionScanner, e.g. for auditing or compliance.
+type RetentionHook interface {
+	// BeforeDelete is called immediately before a message would be
+	// deleted.  Returning skip=true retains the message for this pass.
+	BeforeDelete(msg Message) (skip bool, err error)
+	// AfterDelete is called once a deletion has been attempted, or
+	// simulated under DryRun; err is nil on success.  ctx is the scan's
+	// context, so a hook making its own I/O (e.g. WebhookHook) can bound
+	// it to the scan's lifetime instead of blocking shutdown indefinitely.
+	AfterDelete(ctx context.Context, msg Message, err error)
+}
+
+// AddHook registers a RetentionHook to be consulted before and after
+// every deletion.  Hooks run in registration order.
+func (rs *RetentionScanner) AddHook(hook RetentionHook) {
+	rs.hooksMu.Lock()
+	rs.hooks = append(rs.hooks, hook)
+	rs.hooksMu.Unlock()
+}
+
+func (rs *RetentionScanner) fireBeforeDelete(msg Message) (skip bool, err error) {
+	rs.hooksMu.Lock()
+	hooks := rs.hooks
+	rs.hooksMu.Unlock()
+	for _, h := range hooks {
+		if skip, err = h.BeforeDelete(msg); err != nil || skip {
+			return skip, err
+		}
+	}
+	return false, nil
+}
+
+func (rs *RetentionScanner) fireAfterDelete(ctx context.Context, msg Message, err error) {
+	rs.hooksMu.Lock()
+	hooks := rs.hooks
+	rs.hooksMu.Unlock()
+	for _, h := range hooks {
+		h.AfterDelete(ctx, msg, err)
+	}
 }
 
 // NewRetentionScanner launches a go-routine that scans for expired
-// messages, following the configured interval
-func NewRetentionScanner(ds DataStore, shutdownChannel chan bool) *RetentionScanner {
+// messages, following the configured interval.  The scanner will stop as
+// soon as ctx is cancelled.
+func NewRetentionScanner(ctx context.Context, ds DataStore) *RetentionScanner {
 	cfg := config.GetDataStoreConfig()
+	ctx, cancel := context.WithCancel(ctx)
+	maxTimeSkew := time.Duration(cfg.MaxTimeSkew) * time.Minute
+	if maxTimeSkew <= 0 {
+		maxTimeSkew = defaultMaxTimeSkew
+	}
+	concurrency := cfg.RetentionConcurrency
+	if concurrency < 1 {
+		// Preserve current (serial) behavior by default.
+		concurrency = 1
+	}
+	bloomRebuildEvery := uint64(cfg.BloomRebuildInterval)
+	if bloomRebuildEvery < 1 {
+		// Bound how stale the bloom filter can get: even if a mailbox is
+		// never flagged by NotifyStored or seed, a full unfiltered pass
+		// runs at least this often so newly-expiring messages are found.
+		bloomRebuildEvery = 10
+	}
 	rs := &RetentionScanner{
-		globalShutdown:    shutdownChannel,
+		ctx:               ctx,
+		cancel:            cancel,
 		retentionShutdown: make(chan bool),
 		ds:                ds,
 		retentionPeriod:   time.Duration(cfg.RetentionMinutes) * time.Minute,
 		retentionSleep:    time.Duration(cfg.RetentionSleep) * time.Millisecond,
+		maxTimeSkew:       maxTimeSkew,
+		status:            RetentionStatus(cfg.RetentionStatus),
+		filter:            bloomfilter.New(cfg.BloomFilterSize, 4),
+		mboxFilter:        bloomfilter.New(cfg.BloomFilterSize, 4),
+		bloomRebuildEvery: bloomRebuildEvery,
+		concurrency:       concurrency,
+		limiter:           newRateLimiter(cfg.RetentionDeletesPerSecond),
+	}
+	rs.policies = &retention.ListStore{
+		Default: retention.Policy{MaxAge: rs.retentionPeriod},
 	}
 	// expRetentionPeriod is displayed on the status page
 	expRetentionPeriod.Set(int64(cfg.RetentionMinutes * 60))
 	return rs
 }
 
-// Start up the retention scanner if retention period > 0
-func (rs *RetentionScanner) Start() {
+// SetPolicyStore overrides the scanner's default PolicyStore, letting
+// operators supply per-mailbox or per-domain retention overrides instead
+// of the single global RetentionMinutes value.
+func (rs *RetentionScanner) SetPolicyStore(policies retention.PolicyStore) {
+	rs.policies = policies
+}
+
+// NotifyStored adds id to the bloom filter if date is already within
+// maxTimeSkew of becoming expired, so a later scan can recognize its
+// mailbox as worth opening without consulting the backing store.  This
+// only catches messages that are already close to expiry at store time;
+// doScan is responsible for re-seeding the filter as messages it already
+// knows about age into that window (see seed and bloomRebuildEvery).
+//
+// DataStore implementations should call this whenever a message is
+// stored, so the filter tracks newly-written messages incrementally
+// instead of relying solely on doScan's own re-seeding and its periodic
+// forceFull passes to notice them. No concrete DataStore in this tree
+// calls NotifyStored yet; wiring it into the Store path is a prerequisite
+// for that incremental half of this feature to take effect.
+func (rs *RetentionScanner) NotifyStored(mailboxName, id string, date time.Time) {
+	if rs.status == RetentionDisabled {
+		return
+	}
+	cutoff := time.Now().Add(-1*rs.retentionPeriod + rs.maxTimeSkew)
+	if date.After(cutoff) {
+		// Not close to expiring yet, no need to track it.
+		return
+	}
+	rs.seed(mailboxName, id)
+}
+
+// seed adds mailboxName and id to the bloom filters, so future scans keep
+// recognizing them as worth checking until they are actually deleted.
+func (rs *RetentionScanner) seed(mailboxName, id string) {
+	rs.mu.Lock()
+	rs.filter.Add(id)
+	rs.mboxFilter.Add(mailboxName)
+	rs.mu.Unlock()
+}
+
+// Start registers the scanner as a "retention" task on reg if the
+// retention period is > 0, so it runs on a recurring schedule and can be
+// triggered on demand via reg.RunNow.
+func (rs *RetentionScanner) Start(reg *cron.Registry) error {
 	if rs.retentionPeriod <= 0 {
 		log.Infof("Retention scanner disabled")
+		// rs.limiter is created unconditionally by NewRetentionScanner;
+		// close it here too since awaitShutdown never runs on this
+		// early-return path.
+		rs.limiter.Close()
 		close(rs.retentionShutdown)
-		return
+		return nil
 	}
 	log.Infof("Retention configured for %v", rs.retentionPeriod)
-	go rs.run()
-}
-
-// run loops to kick off the scanner on the correct schedule
-func (rs *RetentionScanner) run() {
-	start := time.Now()
-retentionLoop:
-	for {
-		// Prevent scanner from starting more than once a minute
-		since := time.Since(start)
-		if since < time.Minute {
-			dur := time.Minute - since
-			log.Tracef("Retention scanner sleeping for %v", dur)
-			select {
-			case <-rs.globalShutdown:
-				break retentionLoop
-			case <-time.After(dur):
-			}
-		}
-		// Kickoff scan
-		start = time.Now()
-		if err := rs.doScan(); err != nil {
-			log.Errorf("Error during retention scan: %v", err)
-		}
-		// Check for global shutdown
-		select {
-		case <-rs.globalShutdown:
-			break retentionLoop
-		default:
-		}
+	if err := reg.Register(rs.ctx, &cron.Task{
+		Name:     retentionTaskName,
+		Schedule: time.Minute,
+		Run:      rs.runScan,
+	}); err != nil {
+		return err
 	}
+	go rs.awaitShutdown()
+	return nil
+}
+
+// runScan wraps doScan so scanWG tracks every execution the cron.Registry
+// launches (scheduled or via RunNow), letting awaitShutdown wait for a scan
+// in flight at shutdown time to actually finish instead of leaking.
+func (rs *RetentionScanner) runScan(ctx context.Context) error {
+	rs.scanWG.Add(1)
+	defer rs.scanWG.Done()
+	return rs.doScan(ctx)
+}
+
+// awaitShutdown waits for the scanner's context to be cancelled and for any
+// doScan execution already in flight to finish, so Shutdown's timeout
+// reflects real completion rather than just context cancellation.
+func (rs *RetentionScanner) awaitShutdown() {
+	<-rs.ctx.Done()
+	rs.scanWG.Wait()
+	rs.limiter.Close()
 	log.Tracef("Retention scanner shut down")
 	close(rs.retentionShutdown)
 }
 
-// doScan does a single pass of all mailboxes looking for messages that can be purged
-func (rs *RetentionScanner) doScan() error {
+// doScan does a single pass of all mailboxes looking for messages that can
+// be purged, fanning the work out across rs.concurrency workers.
+func (rs *RetentionScanner) doScan(ctx context.Context) error {
 	log.Tracef("Starting retention scan")
-	cutoff := time.Now().Add(-1 * rs.retentionPeriod)
-	mboxes, err := rs.ds.AllMailboxes()
+	// Every bloomRebuildEvery'th pass bypasses both bloom filters
+	// entirely, so a mailbox or message that NotifyStored/seed never
+	// flagged (e.g. because it aged into the retention window between
+	// scans, rather than at store time) is still found eventually instead
+	// of being silently skipped forever.
+	cycle := atomic.AddUint64(&rs.scanCount, 1)
+	forceFull := cycle%rs.bloomRebuildEvery == 0
+	if forceFull {
+		log.Tracef("Retention scan %d performing full unfiltered pass", cycle)
+	}
+	mboxes, err := rs.ds.AllMailboxes(ctx)
 	if err != nil {
 		return err
 	}
-	retained := 0
-	// Loop over all mailboxes
+	queue := make(chan Mailbox, len(mboxes))
 	for _, mb := range mboxes {
-		messages, err := mb.GetMessages()
-		if err != nil {
-			return err
-		}
-		// Loop over all messages in mailbox
-		for _, msg := range messages {
-			if msg.Date().Before(cutoff) {
-				log.Tracef("Purging expired message %v", msg.ID())
-				err = msg.Delete()
+		queue <- mb
+	}
+	close(queue)
+
+	queueDepth := int64(len(mboxes))
+	expRetentionMailboxQueueDepth.Set(queueDepth)
+
+	var retained int64
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var scanErr error
+	for i := 0; i < rs.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expRetentionWorkersActive.Add(1)
+			defer expRetentionWorkersActive.Add(-1)
+			for mb := range queue {
+				expRetentionMailboxQueueDepth.Set(atomic.AddInt64(&queueDepth, -1))
+				select {
+				case <-ctx.Done():
+					continue
+				default:
+				}
+				kept, err := rs.scanMailbox(ctx, mb, forceFull)
 				if err != nil {
-					// Log but don't abort
-					log.Errorf("Failed to purge message %v: %v", msg.ID(), err)
-				} else {
-					expRetentionDeletesTotal.Add(1)
+					errOnce.Do(func() { scanErr = err })
+					continue
 				}
-			} else {
-				retained++
+				atomic.AddInt64(&retained, int64(kept))
 			}
-		}
-		// Sleep after completing a mailbox
-		select {
-		case <-rs.globalShutdown:
-			log.Tracef("Retention scan aborted due to shutdown")
-			return nil
-		case <-time.After(rs.retentionSleep):
-			// Reduce disk thrashing
-		}
+		}()
+	}
+	wg.Wait()
+
+	if scanErr != nil {
+		return scanErr
+	}
+	select {
+	case <-ctx.Done():
+		log.Tracef("Retention scan aborted due to shutdown")
+		return nil
+	default:
 	}
 	// Update metrics
 	setRetentionScanCompleted(time.Now())
-	expRetainedCurrent.Set(int64(retained))
+	expRetainedCurrent.Set(retained)
 	return nil
 }
 
+// scanMailbox applies the mailbox's retention policy and returns the
+// number of messages retained.  A mailbox that is skipped on the bloom
+// filter's say-so, or whose policy cannot be resolved, is never opened and
+// counts as 0 retained messages.  forceFull bypasses the mailbox-level
+// bloom filter so the mailbox is periodically re-examined even if neither
+// NotifyStored nor seed ever flagged it.
+func (rs *RetentionScanner) scanMailbox(ctx context.Context, mb Mailbox, forceFull bool) (int, error) {
+	policy, err := rs.policies.PolicyFor(mb.Name())
+	if err != nil {
+		log.Errorf("Failed to resolve retention policy for %v: %v", mb.Name(), err)
+		return 0, nil
+	}
+	// The bloom filter only tracks age-based expiry; MaxMessages/MaxBytes
+	// caps have nothing to do with how close a message is to expiring, so
+	// a mailbox governed by either cap must never be skipped on the
+	// filter's say-so.
+	hasCaps := policy.MaxMessages > 0 || policy.MaxBytes > 0
+	if !forceFull && !hasCaps && rs.status != RetentionDisabled && !rs.mailboxMightExpire(mb.Name()) {
+		// Filter says nothing in this mailbox is close to expiring;
+		// skip the IO of opening it.
+		return 0, nil
+	}
+	messages, err := mb.GetMessages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	kept := rs.purgeExpired(ctx, mb.Name(), forceFull, policy, messages)
+	kept = rs.enforceCaps(ctx, policy, kept)
+	// Throttle between mailboxes to reduce disk thrashing.
+	select {
+	case <-ctx.Done():
+	case <-time.After(rs.retentionSleep):
+	}
+	return len(kept), nil
+}
+
+// purgeExpired deletes the messages older than policy.MaxAge, honoring the
+// bloom filter pre-check and RetentionDebug, and returns those that
+// survive for further cap enforcement.  mailboxName is being scanned here
+// because the filter flagged it, or because forceFull, a MaxMessages/MaxBytes
+// cap or RetentionDisabled bypassed that check; either way, every message
+// that is getting close to policy.MaxAge is
+// re-seeded into the filter so it keeps being recognized on later scans
+// as it continues to age, rather than only ever being caught once at
+// store time.
+func (rs *RetentionScanner) purgeExpired(ctx context.Context, mailboxName string, forceFull bool, policy retention.Policy, messages []Message) []Message {
+	kept := make([]Message, 0, len(messages))
+	if policy.MaxAge <= 0 {
+		return append(kept, messages...)
+	}
+	cutoff := time.Now().Add(-1 * policy.MaxAge)
+	nearCutoff := cutoff.Add(rs.maxTimeSkew)
+	for _, msg := range messages {
+		if msg.Date().Before(nearCutoff) {
+			rs.seed(mailboxName, msg.ID())
+		}
+		if !forceFull && rs.status != RetentionDisabled && !rs.messageMightExpire(msg.ID()) {
+			kept = append(kept, msg)
+			continue
+		}
+		if !msg.Date().Before(cutoff) {
+			kept = append(kept, msg)
+			continue
+		}
+		if _, removed := rs.purgeOne(ctx, msg); !removed {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// enforceCaps deletes the oldest messages in excess of policy's
+// MaxMessages and MaxBytes limits.
+func (rs *RetentionScanner) enforceCaps(ctx context.Context, policy retention.Policy, messages []Message) []Message {
+	if policy.MaxMessages <= 0 && policy.MaxBytes <= 0 {
+		return messages
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Date().Before(messages[j].Date())
+	})
+	var total int64
+	for _, msg := range messages {
+		total += msg.Size()
+	}
+	kept := make([]Message, 0, len(messages))
+	for i, msg := range messages {
+		remaining := len(messages) - i
+		overCount := policy.MaxMessages > 0 && remaining > policy.MaxMessages
+		overBytes := policy.MaxBytes > 0 && total > policy.MaxBytes
+		if !overCount && !overBytes {
+			kept = append(kept, messages[i:]...)
+			break
+		}
+		accountedGone, removed := rs.purgeOne(ctx, msg)
+		if accountedGone {
+			total -= msg.Size()
+		}
+		if !removed {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// purgeOne consults registered hooks and DryRun before deleting msg.
+// accountedGone reports whether msg should be treated as gone for cap
+// bookkeeping purposes (true for both a real delete and a simulated
+// DryRun/Debug one); removed reports whether msg.Delete was actually
+// called and succeeded, i.e. whether it must be dropped from the caller's
+// kept list.
+func (rs *RetentionScanner) purgeOne(ctx context.Context, msg Message) (accountedGone, removed bool) {
+	skip, err := rs.fireBeforeDelete(msg)
+	if err != nil {
+		log.Errorf("Retention hook rejected purge of %v: %v", msg.ID(), err)
+		return false, false
+	}
+	if skip {
+		log.Tracef("Retention hook skipped purge of %v", msg.ID())
+		return false, false
+	}
+	if rs.DryRun || rs.status == RetentionDebug {
+		log.Infof("Retention dry-run: would purge message %v", msg.ID())
+		rs.fireAfterDelete(ctx, msg, nil)
+		return true, false
+	}
+	if err := rs.limiter.Wait(ctx); err != nil {
+		return false, false
+	}
+	log.Tracef("Purging message %v", msg.ID())
+	delErr := msg.Delete(ctx)
+	rs.fireAfterDelete(ctx, msg, delErr)
+	if delErr != nil {
+		// Log but don't abort
+		log.Errorf("Failed to purge message %v: %v", msg.ID(), delErr)
+		return false, false
+	}
+	expRetentionDeletesTotal.Add(1)
+	return true, true
+}
+
+// mailboxMightExpire consults the bloom filter to decide whether mailbox
+// name is worth opening.  A false result means it definitely contains no
+// message close to expiring; a true result may be a false positive.
+func (rs *RetentionScanner) mailboxMightExpire(name string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.mboxFilter.Test(name)
+}
+
+// messageMightExpire consults the bloom filter to decide whether id is
+// worth the cost of a real date check.  A false result means id is
+// definitely not expiring; a true result may be a false positive, so
+// callers must still verify the message's Date before deleting it.
+func (rs *RetentionScanner) messageMightExpire(id string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.filter.Test(id)
+}
+
 // Join does not retun until the retention scanner has shut down
 func (rs *RetentionScanner) Join() {
 	if rs.retentionShutdown != nil {
@@ -163,6 +524,19 @@ func (rs *RetentionScanner) Join() {
 	}
 }
 
+// Shutdown cancels the scanner's context and waits up to timeout for the
+// scan loop to exit cleanly, hammer-style: callers that need a hard
+// deadline can rely on this returning promptly even if a scan is stuck.
+func (rs *RetentionScanner) Shutdown(timeout time.Duration) error {
+	rs.cancel()
+	select {
+	case <-rs.retentionShutdown:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("retention scanner did not shut down within %v", timeout)
+	}
+}
+
 func setRetentionScanCompleted(t time.Time) {
 	retentionScanCompletedMu.Lock()
 	defer retentionScanCompletedMu.Unlock()
@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jhillyerd/inbucket/log"
+)
+
+// auditRecord is the payload shared by the built-in RetentionHook
+// implementations, recording enough detail to forensically review a
+// deletion after the fact.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Mailbox string    `json:"mailbox"`
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+	Age     string    `json:"age"`
+}
+
+func newAuditRecord(msg Message) auditRecord {
+	return auditRecord{
+		Time:    time.Now(),
+		Mailbox: msg.Mailbox(),
+		ID:      msg.ID(),
+		Size:    msg.Size(),
+		From:    msg.From(),
+		Subject: msg.Subject(),
+		Age:     time.Since(msg.Date()).String(),
+	}
+}
+
+// JSONLAuditHook is a built-in RetentionHook that appends one JSON record
+// per deletion to a log file, so policy changes can be reviewed before
+// operators trust plain deletion.
+type JSONLAuditHook struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJSONLAuditHook opens path for appending (creating it if necessary)
+// and returns a hook that writes a record to it for every deletion.
+func NewJSONLAuditHook(path string) (*JSONLAuditHook, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLAuditHook{file: f}, nil
+}
+
+// BeforeDelete implements RetentionHook; it never skips a deletion.
+func (h *JSONLAuditHook) BeforeDelete(msg Message) (bool, error) {
+	return false, nil
+}
+
+// AfterDelete implements RetentionHook, appending an audit record for the
+// deletion regardless of whether it succeeded.
+func (h *JSONLAuditHook) AfterDelete(ctx context.Context, msg Message, err error) {
+	rec := newAuditRecord(msg)
+	line, merr := json.Marshal(rec)
+	if merr != nil {
+		log.Errorf("JSONL retention audit hook failed to encode record for %v: %v", msg.ID(), merr)
+		return
+	}
+	line = append(line, '\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, werr := h.file.Write(line); werr != nil {
+		log.Errorf("JSONL retention audit hook failed to write record for %v: %v", msg.ID(), werr)
+	}
+}
+
+// Close closes the underlying audit log file.
+func (h *JSONLAuditHook) Close() error {
+	return h.file.Close()
+}
+
+// WebhookHook is a built-in RetentionHook that POSTs the same audit
+// payload used by JSONLAuditHook to a configured URL after each deletion.
+type WebhookHook struct {
+	URL    string
+	Client *http.Client
+}
+
+// defaultWebhookTimeout bounds how long AfterDelete can block the
+// retention worker that calls it when no client is supplied.  AfterDelete
+// is invoked synchronously from purgeOne, so a hung endpoint must not be
+// allowed to stall a scan (and shutdown) indefinitely.
+const defaultWebhookTimeout = 10 * time.Second
+
+// NewWebhookHook returns a hook that POSTs JSON audit records to url. If
+// client is nil, one with defaultWebhookTimeout is created; a
+// caller-supplied client should set its own Timeout for the same reason.
+func NewWebhookHook(url string, client *http.Client) *WebhookHook {
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	return &WebhookHook{URL: url, Client: client}
+}
+
+// BeforeDelete implements RetentionHook; it never skips a deletion.
+func (h *WebhookHook) BeforeDelete(msg Message) (bool, error) {
+	return false, nil
+}
+
+// AfterDelete implements RetentionHook, POSTing an audit record for the
+// deletion regardless of whether it succeeded.  ctx bounds the request in
+// addition to any timeout already set on h.Client, so a cancelled scan
+// doesn't wait on a hung endpoint.
+func (h *WebhookHook) AfterDelete(ctx context.Context, msg Message, err error) {
+	rec := newAuditRecord(msg)
+	body, merr := json.Marshal(rec)
+	if merr != nil {
+		log.Errorf("Webhook retention hook failed to encode record for %v: %v", msg.ID(), merr)
+		return
+	}
+	req, rerr := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if rerr != nil {
+		log.Errorf("Webhook retention hook failed to build request for %v: %v", msg.ID(), rerr)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, perr := h.Client.Do(req)
+	if perr != nil {
+		log.Errorf("Webhook retention hook failed to POST record for %v: %v", msg.ID(), perr)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Errorf("Webhook retention hook got status %v posting record for %v", resp.Status, msg.ID())
+	}
+}
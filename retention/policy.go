@@ -0,0 +1,57 @@
+// Package retention defines per-mailbox message retention policies, so
+// operators can override the datastore-wide retention period for specific
+// mailboxes or domains instead of being stuck with a single global value.
+package retention
+
+import (
+	"path"
+	"time"
+)
+
+// Policy describes the retention limits that apply to mailboxes matching
+// MailboxPattern.  A zero value for MaxMessages or MaxBytes means that
+// limit is not enforced.
+type Policy struct {
+	// MailboxPattern is a path.Match-style glob matched against the
+	// mailbox name, e.g. "*@example.com" or "archive-*".
+	MailboxPattern string
+	// MaxAge is the oldest a message in a matching mailbox may get before
+	// it is purged.
+	MaxAge time.Duration
+	// MaxMessages caps the number of messages retained per mailbox,
+	// oldest deleted first once the cap is exceeded.
+	MaxMessages int
+	// MaxBytes caps the total size of messages retained per mailbox,
+	// oldest deleted first once the cap is exceeded.
+	MaxBytes int64
+}
+
+// Matches reports whether mailboxName satisfies p's MailboxPattern.
+func (p Policy) Matches(mailboxName string) bool {
+	ok, err := path.Match(p.MailboxPattern, mailboxName)
+	return err == nil && ok
+}
+
+// PolicyStore resolves the Policy that applies to a given mailbox.
+type PolicyStore interface {
+	// PolicyFor returns the Policy that governs mailboxName.
+	PolicyFor(mailboxName string) (Policy, error)
+}
+
+// ListStore is a PolicyStore backed by an ordered list of overrides,
+// falling back to Default when none match.  The first matching entry
+// wins, so more specific patterns should be listed before general ones.
+type ListStore struct {
+	Default  Policy
+	Policies []Policy
+}
+
+// PolicyFor implements PolicyStore.
+func (s *ListStore) PolicyFor(mailboxName string) (Policy, error) {
+	for _, p := range s.Policies {
+		if p.Matches(mailboxName) {
+			return p, nil
+		}
+	}
+	return s.Default, nil
+}
@@ -0,0 +1,60 @@
+package retention
+
+import "testing"
+
+func TestPolicyMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*@example.com", "user@example.com", true},
+		{"*@example.com", "user@other.com", false},
+		{"archive-*", "archive-2020", true},
+		{"archive-*", "inbox-2020", false},
+		{"[", "anything", false}, // malformed pattern: Matches must not error out
+	}
+	for _, tt := range tests {
+		p := Policy{MailboxPattern: tt.pattern}
+		if got := p.Matches(tt.name); got != tt.want {
+			t.Errorf("Policy{%q}.Matches(%q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListStorePolicyForFirstMatchWins(t *testing.T) {
+	specific := Policy{MailboxPattern: "archive-*", MaxMessages: 100}
+	general := Policy{MailboxPattern: "*", MaxMessages: 10}
+	store := &ListStore{
+		Default:  Policy{MaxMessages: 1},
+		Policies: []Policy{specific, general},
+	}
+
+	got, err := store.PolicyFor("archive-2020")
+	if err != nil {
+		t.Fatalf("PolicyFor: unexpected error: %v", err)
+	}
+	if got != specific {
+		t.Errorf("PolicyFor(archive-2020) = %+v, want %+v (first match, not fallback to general)", got, specific)
+	}
+
+	got, err = store.PolicyFor("inbox-2020")
+	if err != nil {
+		t.Fatalf("PolicyFor: unexpected error: %v", err)
+	}
+	if got != general {
+		t.Errorf("PolicyFor(inbox-2020) = %+v, want %+v", got, general)
+	}
+}
+
+func TestListStorePolicyForFallsBackToDefault(t *testing.T) {
+	store := &ListStore{Default: Policy{MaxMessages: 1}}
+
+	got, err := store.PolicyFor("anything")
+	if err != nil {
+		t.Fatalf("PolicyFor: unexpected error: %v", err)
+	}
+	if got != store.Default {
+		t.Errorf("PolicyFor(anything) = %+v, want default %+v", got, store.Default)
+	}
+}